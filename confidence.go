@@ -0,0 +1,41 @@
+package indicators
+
+// FireWithConfidence is FireEmissions for soft matches: the leaf matched
+// with confidence c, between 0 and 1 (e.g. from an edit-distance or
+// classifier match). Confidences are combined up the tree, taking the
+// minimum of the children for an AND and the maximum for an OR. If a leaf
+// fires again with more confidence, or another child of a true OR fires
+// with more, the raised confidence propagates up the tree too. A NOT that
+// resolves true has confidence 1.
+// A node's Indicator is emitted, once per event, when its confidence
+// reaches its Threshold. The trace may be nil.
+func (node *IndicatorNode) FireWithConfidence(evID int, c float64, tr *Trace) ([]Emission, []int) {
+	soft := IndicatorNode{truth: truthTrue, confidence: c}
+	return node.setTruth(&soft, evID, tr)
+}
+
+// combinedConfidence returns the confidence of a node that has just become
+// true as a result of childNode.
+func (node *IndicatorNode) combinedConfidence(childNode *IndicatorNode) float64 {
+	switch node.Operator {
+
+	case "AND", "OR":
+		confidence := childNode.confidence
+		for _, child := range node.Children {
+			if child.eventID != node.eventID || child.truth != truthTrue {
+				continue
+			}
+			if node.Operator == "AND" && child.confidence < confidence ||
+				node.Operator == "OR" && child.confidence > confidence {
+				confidence = child.confidence
+			}
+		}
+		return confidence
+
+	case "NOT":
+		return 1
+
+	default:
+		return childNode.confidence // a leaf
+	}
+}
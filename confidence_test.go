@@ -0,0 +1,134 @@
+package indicators
+
+import (
+	"testing"
+
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+func TestFireWithConfidence(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		a, b      float64
+		want      []float64 // confidences of emissions: AND, then OR
+	}{
+		{"and takes minimum", 0, 0.9, 0.6, []float64{0.6, 0.6}},
+		{"or takes maximum", 0, 0.3, 0.8, []float64{0.3, 0.8}},
+		{"below threshold", 0.5, 0.9, 0.4, nil},
+		{"or above threshold", 0.5, 0.4, 0.9, []float64{0.9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// OR(AND(a, b), b) - the OR resolves on b after the AND
+			a, b := leaf("host", "a"), leaf("host", "b")
+			and := &IndicatorNode{Operator: "AND", Children: []*IndicatorNode{a, b},
+				Indicator: &dt.Indicator{Id: "and"}, Threshold: tt.threshold}
+			or := &IndicatorNode{Operator: "OR", Children: []*IndicatorNode{and, b},
+				Indicator: &dt.Indicator{Id: "or"}, Threshold: tt.threshold}
+			link(or)
+
+			var got []float64
+			for _, fire := range []struct {
+				node *IndicatorNode
+				c    float64
+			}{{a, tt.a}, {b, tt.b}} {
+				ems, _ := fire.node.FireWithConfidence(1, fire.c, nil)
+				for _, em := range ems {
+					got = append(got, em.Confidence)
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got confidences %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got confidences %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFireWithConfidenceRaised(t *testing.T) {
+	type firing struct {
+		leaf string
+		c    float64
+	}
+	tests := []struct {
+		name    string
+		tree    func(a, b, c *IndicatorNode) *IndicatorNode
+		firings []firing
+		want    []float64 // confidences emitted by "rule", in order
+	}{
+		{
+			"or crosses threshold on later child",
+			func(a, b, c *IndicatorNode) *IndicatorNode {
+				or := rule("rule", &IndicatorNode{Operator: "OR", Children: []*IndicatorNode{a, b}})
+				or.Threshold = 0.5
+				return or
+			},
+			[]firing{{"a", 0.4}, {"b", 0.9}},
+			[]float64{0.9},
+		},
+		{
+			"or emits once per event",
+			func(a, b, c *IndicatorNode) *IndicatorNode {
+				return rule("rule", &IndicatorNode{Operator: "OR", Children: []*IndicatorNode{a, b}})
+			},
+			[]firing{{"a", 0.4}, {"b", 0.9}},
+			[]float64{0.4},
+		},
+		{
+			"leaf fired again with more confidence",
+			func(a, b, c *IndicatorNode) *IndicatorNode {
+				or := rule("rule", &IndicatorNode{Operator: "OR", Children: []*IndicatorNode{a, b}})
+				or.Threshold = 0.5
+				return or
+			},
+			[]firing{{"a", 0.4}, {"a", 0.6}},
+			[]float64{0.6},
+		},
+		{
+			"raised or propagates to and",
+			func(a, b, c *IndicatorNode) *IndicatorNode {
+				or := &IndicatorNode{Operator: "OR", Children: []*IndicatorNode{a, b}}
+				and := rule("rule", &IndicatorNode{Operator: "AND", Children: []*IndicatorNode{or, c}})
+				and.Threshold = 0.5
+				return and
+			},
+			[]firing{{"a", 0.2}, {"c", 1}, {"b", 0.9}},
+			[]float64{0.9},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaves := map[string]*IndicatorNode{
+				"a": leaf("host", "a"), "b": leaf("host", "b"), "c": leaf("host", "c"),
+			}
+			link(tt.tree(leaves["a"], leaves["b"], leaves["c"]))
+
+			var got []float64
+			for _, f := range tt.firings {
+				ems, _ := leaves[f.leaf].FireWithConfidence(1, f.c, nil)
+				for _, em := range ems {
+					if em.Indicator.Id == "rule" {
+						got = append(got, em.Confidence)
+					}
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got confidences %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got confidences %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
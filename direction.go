@@ -0,0 +1,61 @@
+package indicators
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Directions a pattern of Direction "any" is expanded to. These are the
+// prefixes of the event property names, e.g. "src.ipv4".
+var directions = []string{"src", "dest"}
+
+// ExpandDirections rewrites every leaf whose Pattern has Direction "any"
+// into an OR of one leaf per direction, so rule authors don't have to
+// duplicate each node for src and dest. The rewritten node keeps its ID and
+// Indicator, so references to it are unaffected.
+// It must be called at load time, before links to Parents are created.
+func (defs *IndicatorDefinitions) ExpandDirections() {
+	for _, node := range defs.Definitions {
+		node.expandDirections()
+	}
+}
+
+func (node *IndicatorNode) expandDirections() {
+	for _, child := range node.Children {
+		child.expandDirections()
+	}
+
+	if node.Pattern == nil || node.Pattern.Direction == "" {
+		return
+	}
+	if node.Pattern.Direction != "any" {
+		log.Warnf("Pattern %s has unrecognised direction '%s', not expanded",
+			node.name(), node.Pattern.Direction)
+		return
+	}
+
+	for _, pattern := range node.Pattern.directional() {
+		node.Children = append(node.Children, &IndicatorNode{Pattern: pattern})
+	}
+	node.Operator = "OR"
+	node.Pattern = nil
+}
+
+// directional returns a copy of a Direction "any" pattern for each direction.
+func (p *Pattern) directional() []*Pattern {
+	// Strip any direction the author already gave the type
+	typ := p.Type
+	for _, dir := range directions {
+		typ = strings.TrimPrefix(typ, dir+".")
+	}
+
+	var patterns []*Pattern
+	for _, dir := range directions {
+		pattern := *p
+		pattern.Type = dir + "." + typ
+		pattern.Direction = ""
+		patterns = append(patterns, &pattern)
+	}
+	return patterns
+}
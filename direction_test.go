@@ -0,0 +1,44 @@
+package indicators
+
+import (
+	"testing"
+
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+func TestExpandDirections(t *testing.T) {
+	node := &IndicatorNode{
+		ID:        "bad-ip",
+		Indicator: &dt.Indicator{Id: "bad-ip"},
+		Pattern:   &Pattern{Type: "src.ipv4", Value: "6.6.6.6", Direction: "any"},
+	}
+	other := &IndicatorNode{Pattern: &Pattern{Type: "ipv4", Value: "7.7.7.7", Direction: "src"}}
+	defs := &IndicatorDefinitions{Definitions: []*IndicatorNode{node, other}}
+	defs.ExpandDirections()
+
+	if node.Operator != "OR" || node.Pattern != nil || len(node.Children) != 2 {
+		t.Fatalf("got operator %q, pattern %v, %d children; want OR of 2 leaves",
+			node.Operator, node.Pattern, len(node.Children))
+	}
+	if node.ID != "bad-ip" || node.Indicator == nil {
+		t.Errorf("expanded node lost its ID or Indicator")
+	}
+	for i, typ := range []string{"src.ipv4", "dest.ipv4"} {
+		p := node.Children[i].Pattern
+		if p.Type != typ || p.Value != "6.6.6.6" || p.Direction != "" {
+			t.Errorf("child %d: got pattern %+v, want type %s", i, *p, typ)
+		}
+	}
+	if other.Operator != "" || other.Pattern.Type != "ipv4" {
+		t.Errorf("pattern with unrecognised direction was expanded")
+	}
+
+	link(node)
+	inds, _ := node.Children[1].Fire(1)
+	if len(inds) != 1 || inds[0].Id != "bad-ip" {
+		t.Fatalf("got indicators %v, want bad-ip", inds)
+	}
+	if inds[0].Type != "ipv4" || inds[0].Value != "6.6.6.6" {
+		t.Errorf("got type %s value %s, want ipv4 6.6.6.6", inds[0].Type, inds[0].Value)
+	}
+}
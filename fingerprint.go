@@ -0,0 +1,55 @@
+package indicators
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"strconv"
+)
+
+// Fingerprint returns a stable content hash of the effective rule set made up
+// of the given definitions, so operations can check every sensor in a fleet
+// is running the same intel. Versions, comments and descriptions (which may
+// be localized per sensor) do not affect the fingerprint; everything else
+// that changes matching or emission does.
+// Setting the truth of a node overwrites its Indicator's Type and Value, so
+// the fingerprint should be taken at load time, before any events are seen.
+func Fingerprint(defs ...*IndicatorDefinitions) string {
+	h := sha256.New()
+	for _, def := range defs {
+		for _, node := range def.Definitions {
+			node.fingerprint(h)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fingerprint writes the definition of the node and its children to h.
+// Links to Parents are not followed, they are derived from the children.
+func (node *IndicatorNode) fingerprint(h hash.Hash) {
+	def := *node
+	def.Comment = ""
+	def.Parents = nil
+	def.Children = nil
+	def.Descriptions = nil
+
+	// The deprecated flag and the policy it maps to are the same rule
+	def.ValuePolicy = node.valuePolicy()
+	def.UseOriginalIndicatorValue = false
+
+	if node.Indicator != nil {
+		ind := *node.Indicator
+		ind.Description = ""
+		def.Indicator = &ind
+	}
+
+	// Marshalling a node without its links cannot fail
+	b, _ := json.Marshal(&def)
+	h.Write(b)
+	h.Write([]byte(strconv.Itoa(len(node.Children))))
+
+	for _, child := range node.Children {
+		child.fingerprint(h)
+	}
+}
@@ -0,0 +1,49 @@
+package indicators
+
+import (
+	"testing"
+
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+func TestFingerprint(t *testing.T) {
+	rule := func(modify func(node *IndicatorNode)) string {
+		node := &IndicatorNode{
+			Operator:  "OR",
+			Indicator: &dt.Indicator{Id: "rule", Description: "bad host"},
+			Children:  []*IndicatorNode{leaf("host", "a"), leaf("host", "b")},
+		}
+		modify(node)
+		return Fingerprint(&IndicatorDefinitions{Definitions: []*IndicatorNode{node}})
+	}
+	base := rule(func(*IndicatorNode) {})
+
+	same := map[string]func(node *IndicatorNode){
+		"comment":      func(node *IndicatorNode) { node.Comment = "changed" },
+		"description":  func(node *IndicatorNode) { node.Indicator.Description = "changed" },
+		"descriptions": func(node *IndicatorNode) { node.Descriptions = map[string]string{"fr": "changé"} },
+		"policy":       func(node *IndicatorNode) { node.ValuePolicy = ValuePolicyPattern },
+	}
+	for name, modify := range same {
+		if rule(modify) != base {
+			t.Errorf("%s changed the fingerprint", name)
+		}
+	}
+
+	different := map[string]func(node *IndicatorNode){
+		"pattern":  func(node *IndicatorNode) { node.Children[1].Pattern.Value = "c" },
+		"operator": func(node *IndicatorNode) { node.Operator = "AND" },
+		"id":       func(node *IndicatorNode) { node.Indicator.Id = "other" },
+	}
+	for name, modify := range different {
+		if rule(modify) == base {
+			t.Errorf("%s did not change the fingerprint", name)
+		}
+	}
+
+	legacy := rule(func(node *IndicatorNode) { node.UseOriginalIndicatorValue = true })
+	policy := rule(func(node *IndicatorNode) { node.ValuePolicy = ValuePolicyOriginal })
+	if legacy != policy {
+		t.Errorf("UseOriginalIndicatorValue and ValuePolicyOriginal fingerprint differently")
+	}
+}
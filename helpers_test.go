@@ -0,0 +1,30 @@
+package indicators
+
+import (
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+// Helpers for building node trees in tests.
+
+// link creates the links to Parents, as done at load time.
+func link(node *IndicatorNode) *IndicatorNode {
+	for _, child := range node.Children {
+		child.Parents = append(child.Parents, node)
+		link(child)
+	}
+	return node
+}
+
+func leaf(typ, value string) *IndicatorNode {
+	return &IndicatorNode{Pattern: &Pattern{Type: typ, Value: value}}
+}
+
+func not(child *IndicatorNode) *IndicatorNode {
+	return &IndicatorNode{Operator: "NOT", Children: []*IndicatorNode{child}}
+}
+
+// rule gives the node an Indicator with the given ID.
+func rule(id string, node *IndicatorNode) *IndicatorNode {
+	node.Indicator = &dt.Indicator{Id: id}
+	return node
+}
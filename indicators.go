@@ -1,6 +1,8 @@
 package indicators
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -58,11 +60,29 @@ type IndicatorNode struct {
 	Children    []*IndicatorNode `json:"children,omitempty"`
 	SiblingNots []int            `json:"siblingnots,omitempty"`
 	Pattern     *Pattern         `json:"pattern,omitempty"`
+	Sample      int              `json:"sample,omitempty"`    // emit the first of every N firings
+	Threshold   float64          `json:"threshold,omitempty"` // minimum confidence to emit
+
+	// Indicator descriptions keyed by language code, see Localize
+	Descriptions map[string]string `json:"descriptions,omitempty"`
+
+	// How the Indicator's value is set when the node fires, see ValuePolicy
+	ValuePolicy   ValuePolicy `json:"valuepolicy,omitempty"`
+	ValueTemplate string      `json:"valuetemplate,omitempty"` // for ValuePolicyTemplate
+
+	// UseOriginalIndicatorValue keeps the value the Indicator was defined
+	// with, rather than setting it from the matched pattern.
+	//
+	// Deprecated: use ValuePolicy "original" instead.
+	UseOriginalIndicatorValue bool
 
 	// Runtime state:
-	truth   truth // the 'truth' of this node, maybe unknown
-	eventID int   // the event ID currently being processed
-	UseOriginalIndicatorValue bool // decide whether to fetch the indicator value from children
+	truth      truth   // the 'truth' of this node, maybe unknown
+	eventID    int     // the event ID currently being processed
+	hits       int     // firings in the current Sample window
+	suppressed int     // firings not emitted since the Indicator was last emitted
+	confidence float64 // confidence in the node being true, see FireWithConfidence
+	emitted    bool    // whether the Indicator has been emitted for the event
 }
 
 // Pattern is the pattern to match on
@@ -74,31 +94,117 @@ type IndicatorNode struct {
 //    - int (an integer match of Value)
 //    - range (an integer range match of Value-Value2 inclusive)
 //    - dns (a DNS hostname match of Value)
+// Direction "any" makes an address pattern match as either source or
+// destination; see ExpandDirections
 type Pattern struct {
-	Type   string `json:"type,omitempty"`
-	Value  string `json:"value,omitempty"`
-	Value2 string `json:"value2,omitempty"`
-	Match  string `json:"match,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Value2    string `json:"value2,omitempty"`
+	Match     string `json:"match,omitempty"`
+	Direction string `json:"direction,omitempty"`
 }
 
-var trueNode = IndicatorNode{truth: truthTrue}
+// Emission is an Indicator emitted by a node, with the number of times the
+// node fired for it and the confidence in the node being true (1 unless
+// FireWithConfidence is used). The count is 1 without sampling; with a Sample
+// of N it is 1 for the node's first emission and N after that, as each
+// emission then also stands for the firings suppressed before it.
+type Emission struct {
+	Indicator  *dt.Indicator
+	Count      int
+	Confidence float64
+}
+
+var trueNode = IndicatorNode{truth: truthTrue, confidence: 1}
 var falseNode = IndicatorNode{truth: truthFalse}
 
 // Fire should be called when node becomes True, i.e. the node resolves to true
 // because its condition is satisfied (e.g, due to pattern patch or boolean
 // operator being true)
 func (node *IndicatorNode) Fire(evID int) ([]*dt.Indicator, []int) {
-	return node.setTruth(&trueNode, evID)
+	return indicatorsOf(node.setTruth(&trueNode, evID, nil))
+}
+
+// FireWithTrace is Fire, additionally recording each propagation step
+// in the trace.
+func (node *IndicatorNode) FireWithTrace(evID int, tr *Trace) ([]*dt.Indicator, []int) {
+	return indicatorsOf(node.setTruth(&trueNode, evID, tr))
+}
+
+// FireEmissions is Fire, returning the Emissions rather than just the
+// Indicators, so callers can see how many firings each sampled Indicator
+// stands for. The trace may be nil.
+func (node *IndicatorNode) FireEmissions(evID int, tr *Trace) ([]Emission, []int) {
+	return node.setTruth(&trueNode, evID, tr)
 }
 
 // ResolveNot should be called to resolve the truth of NOT nodes, given
 // the knowledge that its child can now be assumed truthFalse.
 func (node *IndicatorNode) ResolveNot(evID int) ([]*dt.Indicator, []int) {
-	return node.setTruth(&falseNode, evID)
+	return indicatorsOf(node.setTruth(&falseNode, evID, nil))
+}
+
+// ResolveNotWithTrace is ResolveNot, additionally recording each propagation
+// step in the trace.
+func (node *IndicatorNode) ResolveNotWithTrace(evID int, tr *Trace) ([]*dt.Indicator, []int) {
+	return indicatorsOf(node.setTruth(&falseNode, evID, tr))
+}
+
+// ResolveNotEmissions is ResolveNot, returning the Emissions rather than
+// just the Indicators. The trace may be nil.
+func (node *IndicatorNode) ResolveNotEmissions(evID int, tr *Trace) ([]Emission, []int) {
+	return node.setTruth(&falseNode, evID, tr)
+}
+
+// EmissionKey returns a deterministic key for an emitted indicator, built
+// from the indicator (rule) ID, the key of the event it fired on and the
+// indicator's emitted Value. Replaying the same event yields the same key, so
+// downstream at-least-once pipelines can use it to deduplicate emissions.
+// The emitted Value is the matched value unless the node's ValuePolicy says
+// otherwise, e.g. with ValuePolicyOriginal it is the value the Indicator was
+// defined with, so all emissions of the rule for an event share one key.
+func EmissionKey(ind *dt.Indicator, eventKey string) string {
+	h := sha256.New()
+	for _, s := range []string{ind.Id, eventKey, ind.Value} {
+		h.Write([]byte(s))
+		h.Write([]byte{0}) // separator, so ("ab","c") != ("a","bc")
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 //// Private methods ////
 
+// indicatorsOf strips the counts from emissions.
+func indicatorsOf(emissions []Emission, nots []int) ([]*dt.Indicator, []int) {
+	var indicators []*dt.Indicator
+	for _, e := range emissions {
+		indicators = append(indicators, e.Indicator)
+	}
+	return indicators, nots
+}
+
+// sampled records a firing of the node and reports whether its Indicator
+// should be emitted, i.e. whether this is the first firing of a Sample
+// window, and if so how many firings the emission stands for. Emitting the
+// first rather than the last firing of a window means rules that fire
+// less than Sample times are still seen.
+func (node *IndicatorNode) sampled() (int, bool) {
+	node.hits++
+	if node.Sample > 1 && node.hits > 1 {
+		if node.hits == node.Sample {
+			node.hits = 0
+		}
+		node.suppressed++
+		return 0, false
+	}
+	if node.Sample <= 1 {
+		node.hits = 0
+	}
+	count := node.suppressed + 1
+	node.suppressed = 0
+	return count, true
+}
+
 // setTruth attempts to set the truth of the node, according to the state of
 // the child node being passed in. E.g. if this node is an OR and the child
 // is true, then this node becomes true. The result may be that the state of
@@ -106,11 +212,13 @@ func (node *IndicatorNode) ResolveNot(evID int) ([]*dt.Indicator, []int) {
 //
 // The latest true child node's indicator values are propagated up the chain.
 //
+// If tr is not nil, every evaluation of a node is recorded in it.
+//
 // Beware: this function uses recursion
 //
 
-func (node *IndicatorNode) setTruth(childNode *IndicatorNode, evID int) ([]*dt.Indicator, []int) {
-	var indicators []*dt.Indicator
+func (node *IndicatorNode) setTruth(childNode *IndicatorNode, evID int, tr *Trace) ([]Emission, []int) {
+	var emissions []Emission
 	var discoveredNots []int
 
 	discoveredNots = append(discoveredNots, node.SiblingNots...)
@@ -123,6 +231,7 @@ func (node *IndicatorNode) setTruth(childNode *IndicatorNode, evID int) ([]*dt.I
 	if node.eventID != evID {
 		node.truth = truthUnknown
 		node.eventID = evID // remember what the current event is
+		node.emitted = false
 		if node.Operator != "" {
 			node.Pattern = nil
 		}
@@ -176,49 +285,88 @@ func (node *IndicatorNode) setTruth(childNode *IndicatorNode, evID int) ([]*dt.I
 			setNodeTo = childNode.truth // this is a leaf node
 		}
 
+		step := tr.record(node, childNode, setNodeTo)
+
 		// Are we setting this node's truth?
 		if setNodeTo != truthUnknown {
 
 			node.truth = setNodeTo // set the truth of this node
+			if node.truth == truthTrue {
+				node.confidence = node.combinedConfidence(childNode)
+			}
 
-			// If true, see if any Indicators to return
-			if node.truth == truthTrue && node.Indicator != nil {
-				if node.Pattern != nil {
-					if !node.UseOriginalIndicatorValue {
-						parts := strings.Split(node.Pattern.Type, ".")
-						items := len(parts)
-
-						// The match type is in the pattern to start with, and the "src" or "dest"
-						// prefix has to be removed before copying the values into the indicator
-						if items > 2 {
-							log.Warnf("Indicator % type has % parts. Expected 1 or 2.", node.Indicator.Id, items)
-						}
+			emissions, discoveredNots = node.propagate(step, evID, tr, emissions, discoveredNots)
+		}
 
-						if len(parts) > 1 {
-							node.Indicator.Type = parts[1]
-						} else {
-							node.Indicator.Type = parts[0]
-						}
-						node.Indicator.Value = node.Pattern.Value
-					}
-				} else {
-					log.Warnf("Indicator % no pattern", node.Indicator.Id)
-				}
-				indicators = append(indicators, node.Indicator)
+		tr.finished(step)
+
+	} else if node.truth == truthTrue && childNode.truth == truthTrue {
+		// The node is already true, but the child may have raised its
+		// confidence (see FireWithConfidence), which may now reach the
+		// Threshold and raise the confidence of the parents in turn
+		if c := node.combinedConfidence(childNode); c > node.confidence {
+			step := tr.record(node, childNode, truthTrue)
+
+			node.confidence = c
+			if node.Operator == "OR" && childNode.Pattern != nil {
+				node.Pattern = childNode.Pattern // the child the confidence is from
 			}
 
-			// Check any parents to see if they are now satisfied
-			for _, parent := range node.Parents {
-				inds, discNots := parent.setTruth(node, evID)
-				if inds != nil {
-					// Record that these Indicators happened
-					indicators = append(indicators, inds...)
+			emissions, discoveredNots = node.propagate(step, evID, tr, emissions, discoveredNots)
+			tr.finished(step)
+		}
+	}
+	return emissions, discoveredNots
+}
+
+// propagate is called when the node's truth has been set, or its confidence
+// raised. It emits the node's Indicator if the node is true with enough
+// confidence and has not already emitted for the event, then checks the
+// parents, adding what they emit and discover to emissions and nots.
+func (node *IndicatorNode) propagate(step, evID int, tr *Trace, emissions []Emission, nots []int) ([]Emission, []int) {
+
+	// If true, see if any Indicators to return
+	if node.truth == truthTrue && node.Indicator != nil && !node.emitted &&
+		node.confidence >= node.Threshold {
+
+		node.emitted = true
+		if node.Pattern != nil {
+			if node.valuePolicy() != ValuePolicyOriginal {
+				parts := strings.Split(node.Pattern.Type, ".")
+				items := len(parts)
+
+				// The match type is in the pattern to start with, and the "src" or "dest"
+				// prefix has to be removed before copying the values into the indicator
+				if items > 2 {
+					log.Warnf("Indicator % type has % parts. Expected 1 or 2.", node.Indicator.Id, items)
 				}
-				if discNots != nil {
-					discoveredNots = append(discoveredNots, discNots...)
+
+				if len(parts) > 1 {
+					node.Indicator.Type = parts[1]
+				} else {
+					node.Indicator.Type = parts[0]
 				}
+				node.Indicator.Value = node.indicatorValue()
 			}
+		} else {
+			log.Warnf("Indicator % no pattern", node.Indicator.Id)
+		}
+		if count, ok := node.sampled(); ok {
+			emissions = append(emissions, Emission{node.Indicator, count, node.confidence})
+			tr.emitted(step, node.Indicator)
+		}
+	}
+
+	// Check any parents to see if they are now satisfied
+	for _, parent := range node.Parents {
+		ems, discNots := parent.setTruth(node, evID, tr)
+		if ems != nil {
+			// Record that these Indicators happened
+			emissions = append(emissions, ems...)
+		}
+		if discNots != nil {
+			nots = append(nots, discNots...)
 		}
 	}
-	return indicators, discoveredNots
+	return emissions, nots
 }
@@ -0,0 +1,69 @@
+package indicators
+
+import (
+	"testing"
+
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+func TestEmissionKey(t *testing.T) {
+	ind := &dt.Indicator{Id: "rule", Value: "6.6.6.6"}
+	key := EmissionKey(ind, "event-1")
+
+	if EmissionKey(ind, "event-1") != key {
+		t.Errorf("key is not deterministic")
+	}
+	if EmissionKey(ind, "event-2") == key {
+		t.Errorf("different events have the same key")
+	}
+	if EmissionKey(&dt.Indicator{Id: "rule", Value: "7.7.7.7"}, "event-1") == key {
+		t.Errorf("different values have the same key")
+	}
+
+	// The parts are separated, so moving characters between them changes the key
+	ab := EmissionKey(&dt.Indicator{Id: "ab", Value: "v"}, "c")
+	a := EmissionKey(&dt.Indicator{Id: "a", Value: "v"}, "bc")
+	if ab == a {
+		t.Errorf(`("ab","c") and ("a","bc") have the same key`)
+	}
+}
+
+func TestSample(t *testing.T) {
+	tests := []struct {
+		sample int
+		counts []int // count emitted on each firing, 0 if none
+	}{
+		{0, []int{1, 1, 1}},
+		{1, []int{1, 1, 1}},
+		{3, []int{1, 0, 0, 3, 0, 0, 3}},
+	}
+
+	for _, tt := range tests {
+		// OR(AND(a)) with the sampled indicator on the AND and one on the OR
+		a := leaf("host", "a")
+		and := rule("and", &IndicatorNode{Operator: "AND", Children: []*IndicatorNode{a}})
+		and.Sample = tt.sample
+		or := link(rule("or", &IndicatorNode{Operator: "OR", Children: []*IndicatorNode{and}}))
+
+		for i, want := range tt.counts {
+			ems, _ := a.FireEmissions(i+1, nil)
+
+			got := 0
+			sawOr := false
+			for _, em := range ems {
+				switch em.Indicator.Id {
+				case "and":
+					got = em.Count
+				case "or":
+					sawOr = true
+				}
+			}
+			if got != want {
+				t.Errorf("sample %d, firing %d: got count %d, want %d", tt.sample, i+1, got, want)
+			}
+			if !sawOr || or.truth != truthTrue {
+				t.Errorf("sample %d, firing %d: truth did not propagate to parent", tt.sample, i+1)
+			}
+		}
+	}
+}
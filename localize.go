@@ -0,0 +1,28 @@
+package indicators
+
+// Localize sets the Description of every Indicator to the first of the
+// node's Descriptions available in the preferred languages, given in order
+// of preference, e.g. Localize("fr", "en"). Indicators with none of the
+// preferred languages keep the description they were defined with.
+// It should be called at load time, once the preference order is known.
+func (defs *IndicatorDefinitions) Localize(languages ...string) {
+	for _, node := range defs.Definitions {
+		node.localize(languages)
+	}
+}
+
+func (node *IndicatorNode) localize(languages []string) {
+	for _, child := range node.Children {
+		child.localize(languages)
+	}
+
+	if node.Indicator == nil {
+		return
+	}
+	for _, lang := range languages {
+		if desc, ok := node.Descriptions[lang]; ok {
+			node.Indicator.Description = desc
+			return
+		}
+	}
+}
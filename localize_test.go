@@ -0,0 +1,50 @@
+package indicators
+
+import (
+	"testing"
+
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+func TestLocalize(t *testing.T) {
+	localized := func(id string) *IndicatorNode {
+		return &IndicatorNode{
+			Indicator:    &dt.Indicator{Id: id, Description: "original"},
+			Descriptions: map[string]string{"en": "bad host", "fr": "hôte malveillant"},
+		}
+	}
+
+	top := localized("top")
+	nested := localized("nested")
+	plain := &IndicatorNode{Descriptions: map[string]string{"fr": "sans indicateur"}}
+	top.Operator = "OR"
+	top.Children = []*IndicatorNode{{Operator: "AND", Children: []*IndicatorNode{nested, plain}}}
+
+	tests := []struct {
+		languages []string
+		want      string
+	}{
+		{[]string{"fr", "en"}, "hôte malveillant"},
+		{[]string{"de", "en", "fr"}, "bad host"},
+		{[]string{"de"}, "original"},
+		{nil, "original"},
+	}
+
+	for _, tt := range tests {
+		top.Indicator.Description = "original"
+		nested.Indicator.Description = "original"
+
+		defs := &IndicatorDefinitions{Definitions: []*IndicatorNode{top}}
+		defs.Localize(tt.languages...)
+
+		for _, node := range []*IndicatorNode{top, nested} {
+			if node.Indicator.Description != tt.want {
+				t.Errorf("%v: %s got description %q, want %q",
+					tt.languages, node.Indicator.Id, node.Indicator.Description, tt.want)
+			}
+		}
+		if plain.Indicator != nil {
+			t.Errorf("%v: node without an Indicator was given one", tt.languages)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package indicators
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// NormalizeNegations rewrites the definitions into negation normal form,
+// pushing NOT operators down to the leaves with De Morgan's laws:
+//
+//	NOT (a AND b) => (NOT a) OR (NOT b)
+//	NOT (a OR b)  => (NOT a) AND (NOT b)
+//	NOT NOT a     => a
+//
+// With NOTs only directly above leaves, end-of-event resolution only ever
+// has to resolve NOTs of patterns.
+// Only NOT nodes are rewritten, keeping their ID and Indicator; nodes below
+// a NOT are never dissolved if they have an ID, an Indicator or are a Ref,
+// as that would change what is referenced or emitted.
+// The rewrite moves NOT nodes, so SiblingNots must be computed afterwards.
+// Definitions that already carry SiblingNots are left as they are, with a
+// warning, since their indices would no longer be right.
+// It must be called at load time, before links to Parents are created.
+func (defs *IndicatorDefinitions) NormalizeNegations() {
+	for _, node := range defs.Definitions {
+		if node.hasSiblingNots() {
+			log.Warnf("Definition %s has siblingnots, not normalizing negations", node.name())
+			continue
+		}
+		node.normalizeNegations()
+	}
+}
+
+func (node *IndicatorNode) hasSiblingNots() bool {
+	if len(node.SiblingNots) > 0 {
+		return true
+	}
+	for _, child := range node.Children {
+		if child.hasSiblingNots() {
+			return true
+		}
+	}
+	return false
+}
+
+func (node *IndicatorNode) normalizeNegations() {
+	for node.pushNot() {
+	}
+	for _, child := range node.Children {
+		child.normalizeNegations()
+	}
+}
+
+// pushNot applies one De Morgan rewrite to a NOT node, reporting whether
+// the node was changed.
+func (node *IndicatorNode) pushNot() bool {
+	if node.Operator != "NOT" || len(node.Children) != 1 {
+		return false
+	}
+
+	child := node.Children[0]
+	if !child.rewritable() {
+		return false
+	}
+
+	switch child.Operator {
+
+	case "NOT":
+		if len(child.Children) != 1 || !child.Children[0].rewritable() {
+			return false
+		}
+		grandchild := child.Children[0]
+		node.Operator = grandchild.Operator
+		node.Children = grandchild.Children
+		node.Pattern = grandchild.Pattern
+
+	case "AND", "OR":
+		if child.Operator == "AND" {
+			node.Operator = "OR"
+		} else {
+			node.Operator = "AND"
+		}
+		node.Children = nil
+		for _, grandchild := range child.Children {
+			node.Children = append(node.Children, &IndicatorNode{
+				Operator: "NOT",
+				Children: []*IndicatorNode{grandchild},
+			})
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// rewritable reports whether a node can be dissolved by a rewrite without
+// losing anything that is referenced or emitted.
+func (node *IndicatorNode) rewritable() bool {
+	return node.ID == "" && node.Indicator == nil && node.Ref == ""
+}
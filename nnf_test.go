@@ -0,0 +1,114 @@
+package indicators
+
+import (
+	"testing"
+
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+// evaluate fires the named leaves of the tree then resolves its NOTs, inner
+// ones first, as at the end of an event. It returns the emitted indicators.
+func evaluate(root *IndicatorNode, evID int, fire map[string]bool) map[string]bool {
+	emitted := make(map[string]bool)
+	record := func(inds []*dt.Indicator, _ []int) {
+		for _, ind := range inds {
+			emitted[ind.Id] = true
+		}
+	}
+
+	var nots []*IndicatorNode
+	var walk func(node *IndicatorNode)
+	walk = func(node *IndicatorNode) {
+		for _, child := range node.Children {
+			walk(child)
+		}
+		if node.Operator == "" && fire[node.Pattern.Value] {
+			record(node.Fire(evID))
+		}
+		if node.Operator == "NOT" {
+			nots = append(nots, node)
+		}
+	}
+	walk(root)
+
+	for _, node := range nots {
+		if node.eventID != evID || node.truth == truthUnknown {
+			record(node.ResolveNot(evID))
+		}
+	}
+	return emitted
+}
+
+func TestNormalizeNegations(t *testing.T) {
+	trees := map[string]func() *IndicatorNode{
+		"not and": func() *IndicatorNode {
+			return not(&IndicatorNode{Operator: "AND",
+				Children: []*IndicatorNode{leaf("host", "a"), leaf("host", "b")}})
+		},
+		"not or": func() *IndicatorNode {
+			return not(&IndicatorNode{Operator: "OR",
+				Children: []*IndicatorNode{leaf("host", "a"), leaf("host", "b")}})
+		},
+		"not not": func() *IndicatorNode {
+			return not(not(leaf("host", "a")))
+		},
+		"nested": func() *IndicatorNode {
+			return not(&IndicatorNode{Operator: "OR", Children: []*IndicatorNode{
+				not(leaf("host", "a")),
+				&IndicatorNode{Operator: "AND",
+					Children: []*IndicatorNode{leaf("host", "b"), not(leaf("host", "c"))}},
+			}})
+		},
+	}
+	events := []map[string]bool{
+		{}, {"a": true}, {"b": true}, {"c": true},
+		{"a": true, "b": true}, {"a": true, "c": true}, {"b": true, "c": true},
+		{"a": true, "b": true, "c": true},
+	}
+
+	for name, tree := range trees {
+		t.Run(name, func(t *testing.T) {
+			orig := tree()
+			orig.Indicator = &dt.Indicator{Id: "rule"}
+			norm := tree()
+			norm.Indicator = &dt.Indicator{Id: "rule"}
+			defs := &IndicatorDefinitions{Definitions: []*IndicatorNode{norm}}
+			defs.NormalizeNegations()
+
+			var check func(node *IndicatorNode, underNot bool)
+			check = func(node *IndicatorNode, underNot bool) {
+				if underNot && node.Operator != "" {
+					t.Errorf("NOT above a %s, want only above leaves", node.Operator)
+				}
+				for _, child := range node.Children {
+					check(child, node.Operator == "NOT")
+				}
+			}
+			check(norm, false)
+
+			link(orig)
+			link(norm)
+			for i, fire := range events {
+				want := evaluate(orig, i+1, fire)
+				got := evaluate(norm, i+1, fire)
+				if got["rule"] != want["rule"] {
+					t.Errorf("firing %v: got rule %v, want %v", fire, got["rule"], want["rule"])
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeNegationsKeepsSiblingNots(t *testing.T) {
+	and := &IndicatorNode{Operator: "AND",
+		Children: []*IndicatorNode{leaf("host", "a"), leaf("host", "b")}}
+	root := not(and)
+	and.Children[0].SiblingNots = []int{0}
+
+	defs := &IndicatorDefinitions{Definitions: []*IndicatorNode{root}}
+	defs.NormalizeNegations()
+
+	if root.Operator != "NOT" || root.Children[0] != and {
+		t.Errorf("definition with siblingnots was rewritten")
+	}
+}
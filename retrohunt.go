@@ -0,0 +1,303 @@
+package indicators
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+// RetroHunt holds the queries matching the historical events a rule would
+// have fired on. Pattern Types are used as the column/field names.
+// The SQL is standard SQL: column names are double quoted (MySQL needs
+// ANSI_QUOTES) and compound expressions are parenthesised, so it can be
+// combined with further conditions.
+type RetroHunt struct {
+	Indicator  *dt.Indicator
+	SQL        string                 // a SQL WHERE clause (without "WHERE")
+	OpenSearch map[string]interface{} // an OpenSearch query DSL query
+}
+
+// RetroHuntQueries translates every rule (a node with an Indicator) in the
+// definitions into SQL and OpenSearch queries, so newly loaded IOCs can be
+// retro-hunted across historical data. Rules using a match type that cannot
+// be translated are skipped with a warning.
+// It should be called on the definitions as loaded, before any events are
+// seen, since firing a node changes its Indicator and Pattern.
+func RetroHuntQueries(defs ...*IndicatorDefinitions) []*RetroHunt {
+	ids := make(map[string]*IndicatorNode)
+	for _, def := range defs {
+		for _, node := range def.Definitions {
+			node.collectIDs(ids)
+		}
+	}
+
+	t := &translator{ids: ids, visiting: make(map[*IndicatorNode]bool)}
+	var hunts []*RetroHunt
+	for _, def := range defs {
+		for _, node := range def.Definitions {
+			hunts = t.rules(node, hunts)
+		}
+	}
+	return hunts
+}
+
+func (node *IndicatorNode) collectIDs(ids map[string]*IndicatorNode) {
+	if node.ID != "" {
+		ids[node.ID] = node
+	}
+	for _, child := range node.Children {
+		child.collectIDs(ids)
+	}
+}
+
+type translator struct {
+	ids      map[string]*IndicatorNode
+	visiting map[*IndicatorNode]bool // to detect reference loops
+}
+
+// rules appends the retro-hunt queries of the node and its children.
+func (t *translator) rules(node *IndicatorNode, hunts []*RetroHunt) []*RetroHunt {
+	if node.Indicator != nil {
+		sql, err := t.sql(node)
+		if err == nil {
+			var dsl map[string]interface{}
+			dsl, err = t.openSearch(node)
+			if err == nil {
+				hunts = append(hunts, &RetroHunt{
+					Indicator:  node.Indicator,
+					SQL:        sql,
+					OpenSearch: dsl,
+				})
+			}
+		}
+		if err != nil {
+			log.Warnf("Indicator %s cannot be retro-hunted: %s", node.Indicator.Id, err)
+		}
+	}
+
+	for _, child := range node.Children {
+		hunts = t.rules(child, hunts)
+	}
+	return hunts
+}
+
+// resolve returns the node a reference node refers to.
+func (t *translator) resolve(node *IndicatorNode) (*IndicatorNode, error) {
+	if node.Ref == "" {
+		return node, nil
+	}
+	ref, ok := t.ids[node.Ref]
+	if !ok {
+		return nil, fmt.Errorf("unknown reference '%s'", node.Ref)
+	}
+	return ref, nil
+}
+
+// enter marks the node as being translated, failing if it already is, i.e.
+// if it references itself.
+func (t *translator) enter(node *IndicatorNode) error {
+	if t.visiting[node] {
+		return fmt.Errorf("reference loop at '%s'", node.name())
+	}
+	t.visiting[node] = true
+	return nil
+}
+
+func (t *translator) sql(node *IndicatorNode) (string, error) {
+	node, err := t.resolve(node)
+	if err != nil {
+		return "", err
+	}
+	if err := t.enter(node); err != nil {
+		return "", err
+	}
+	defer delete(t.visiting, node)
+
+	if node.Operator == "" {
+		return patternSQL(node.Pattern)
+	}
+
+	var clauses []string
+	for _, child := range node.Children {
+		clause, err := t.sql(child)
+		if err != nil {
+			return "", err
+		}
+		clauses = append(clauses, clause)
+	}
+	if len(clauses) == 0 {
+		return "", fmt.Errorf("%s has no children", node.Operator)
+	}
+
+	switch node.Operator {
+	case "AND", "OR":
+		return "(" + strings.Join(clauses, " "+node.Operator+" ") + ")", nil
+	case "NOT":
+		// The engine resolves a NOT true when its child never matched, as
+		// OpenSearch must_not matches documents without the field, so rows
+		// where the child is NULL (e.g. the column is NULL) match too
+		clause := clauses[0]
+		if !strings.HasPrefix(clause, "(") {
+			clause = "(" + clause + ")" // a single comparison
+		}
+		return "(NOT " + clause + " OR " + clause + " IS NULL)", nil
+	default:
+		return "", fmt.Errorf("unrecognised operator '%s'", node.Operator)
+	}
+}
+
+func (t *translator) openSearch(node *IndicatorNode) (map[string]interface{}, error) {
+	node, err := t.resolve(node)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.enter(node); err != nil {
+		return nil, err
+	}
+	defer delete(t.visiting, node)
+
+	if node.Operator == "" {
+		return patternOpenSearch(node.Pattern)
+	}
+
+	var queries []interface{}
+	for _, child := range node.Children {
+		query, err := t.openSearch(child)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%s has no children", node.Operator)
+	}
+
+	switch node.Operator {
+	case "AND":
+		return boolQuery("must", queries), nil
+	case "OR":
+		return boolQuery("should", queries), nil
+	case "NOT":
+		return boolQuery("must_not", queries[:1]), nil
+	default:
+		return nil, fmt.Errorf("unrecognised operator '%s'", node.Operator)
+	}
+}
+
+// patternSQL returns a SQL expression matching the pattern, parenthesised
+// unless it is a single comparison.
+func patternSQL(p *Pattern) (string, error) {
+	if p == nil {
+		return "", fmt.Errorf("leaf has no pattern")
+	}
+
+	if p.Direction == "any" {
+		var clauses []string
+		for _, dp := range p.directional() {
+			clause, err := patternSQL(dp)
+			if err != nil {
+				return "", err
+			}
+			clauses = append(clauses, clause)
+		}
+		return "(" + strings.Join(clauses, " OR ") + ")", nil
+	}
+
+	col := `"` + strings.Replace(p.Type, `"`, `""`, -1) + `"`
+
+	switch p.Match {
+	case "", "string":
+		return col + " = " + sqlString(p.Value), nil
+
+	case "int":
+		if _, err := strconv.Atoi(p.Value); err != nil {
+			return "", fmt.Errorf("bad int value '%s'", p.Value)
+		}
+		return col + " = " + p.Value, nil
+
+	case "range":
+		_, err1 := strconv.Atoi(p.Value)
+		_, err2 := strconv.Atoi(p.Value2)
+		if err1 != nil || err2 != nil {
+			return "", fmt.Errorf("bad range '%s'-'%s'", p.Value, p.Value2)
+		}
+		return col + " BETWEEN " + p.Value + " AND " + p.Value2, nil
+
+	case "dns":
+		// The hostname itself, or any name under it
+		suffix := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(p.Value)
+		return "(" + col + " = " + sqlString(p.Value) + " OR " +
+			col + " LIKE " + sqlString("%."+suffix) + " ESCAPE '!')", nil
+
+	default:
+		return "", fmt.Errorf("unsupported match '%s'", p.Match)
+	}
+}
+
+func patternOpenSearch(p *Pattern) (map[string]interface{}, error) {
+	if p == nil {
+		return nil, fmt.Errorf("leaf has no pattern")
+	}
+
+	if p.Direction == "any" {
+		var queries []interface{}
+		for _, dp := range p.directional() {
+			q, err := patternOpenSearch(dp)
+			if err != nil {
+				return nil, err
+			}
+			queries = append(queries, q)
+		}
+		return boolQuery("should", queries), nil
+	}
+
+	switch p.Match {
+	case "", "string":
+		return query("term", p.Type, p.Value), nil
+
+	case "int":
+		v, err := strconv.Atoi(p.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad int value '%s'", p.Value)
+		}
+		return query("term", p.Type, v), nil
+
+	case "range":
+		v1, err1 := strconv.Atoi(p.Value)
+		v2, err2 := strconv.Atoi(p.Value2)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("bad range '%s'-'%s'", p.Value, p.Value2)
+		}
+		return query("range", p.Type, map[string]interface{}{"gte": v1, "lte": v2}), nil
+
+	case "dns":
+		// The hostname itself, or any name under it
+		suffix := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`).Replace(p.Value)
+		return boolQuery("should", []interface{}{
+			query("term", p.Type, p.Value),
+			query("wildcard", p.Type, "*."+suffix),
+		}), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported match '%s'", p.Match)
+	}
+}
+
+func sqlString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func query(kind, field string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{kind: map[string]interface{}{field: value}}
+}
+
+func boolQuery(occur string, queries []interface{}) map[string]interface{} {
+	b := map[string]interface{}{occur: queries}
+	if occur == "should" {
+		b["minimum_should_match"] = 1
+	}
+	return map[string]interface{}{"bool": b}
+}
@@ -0,0 +1,107 @@
+package indicators
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRetroHuntQueries(t *testing.T) {
+	tests := []struct {
+		name string
+		node *IndicatorNode
+		sql  string
+		dsl  string
+	}{
+		{
+			"string",
+			&IndicatorNode{Pattern: &Pattern{Type: "src.ipv4", Value: "6.6.6'6"}},
+			`"src.ipv4" = '6.6.6''6'`,
+			`{"term":{"src.ipv4":"6.6.6'6"}}`,
+		},
+		{
+			"int",
+			&IndicatorNode{Pattern: &Pattern{Type: "dest.port", Value: "53", Match: "int"}},
+			`"dest.port" = 53`,
+			`{"term":{"dest.port":53}}`,
+		},
+		{
+			"range",
+			&IndicatorNode{Pattern: &Pattern{Type: "dest.port", Value: "1", Value2: "1023", Match: "range"}},
+			`"dest.port" BETWEEN 1 AND 1023`,
+			`{"range":{"dest.port":{"gte":1,"lte":1023}}}`,
+		},
+		{
+			"dns",
+			&IndicatorNode{Pattern: &Pattern{Type: "dns", Value: "ev_il.com", Match: "dns"}},
+			`("dns" = 'ev_il.com' OR "dns" LIKE '%.ev!_il.com' ESCAPE '!')`,
+			`{"bool":{"minimum_should_match":1,"should":[{"term":{"dns":"ev_il.com"}},{"wildcard":{"dns":"*.ev_il.com"}}]}}`,
+		},
+		{
+			"direction any",
+			&IndicatorNode{Pattern: &Pattern{Type: "ipv4", Value: "6.6.6.6", Direction: "any"}},
+			`("src.ipv4" = '6.6.6.6' OR "dest.ipv4" = '6.6.6.6')`,
+			`{"bool":{"minimum_should_match":1,"should":[{"term":{"src.ipv4":"6.6.6.6"}},{"term":{"dest.ipv4":"6.6.6.6"}}]}}`,
+		},
+		{
+			"and or not",
+			&IndicatorNode{Operator: "AND", Children: []*IndicatorNode{
+				{Operator: "OR", Children: []*IndicatorNode{
+					leaf("host", "a"), leaf("host", "b"),
+				}},
+				not(leaf("country", "GB")),
+			}},
+			`(("host" = 'a' OR "host" = 'b') AND (NOT ("country" = 'GB') OR ("country" = 'GB') IS NULL))`,
+			`{"bool":{"must":[{"bool":{"minimum_should_match":1,"should":[{"term":{"host":"a"}},{"term":{"host":"b"}}]}},{"bool":{"must_not":[{"term":{"country":"GB"}}]}}]}}`,
+		},
+		{
+			// Rows with no country (NULL) match, as the engine resolves
+			// the NOT true when the leaf never matches
+			"not matches missing fields",
+			not(&IndicatorNode{Operator: "OR", Children: []*IndicatorNode{
+				leaf("country", "GB"), leaf("country", "FR"),
+			}}),
+			`(NOT ("country" = 'GB' OR "country" = 'FR') OR ("country" = 'GB' OR "country" = 'FR') IS NULL)`,
+			`{"bool":{"must_not":[{"bool":{"minimum_should_match":1,"should":[{"term":{"country":"GB"}},{"term":{"country":"FR"}}]}}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs := &IndicatorDefinitions{Definitions: []*IndicatorNode{rule("r", tt.node)}}
+			hunts := RetroHuntQueries(defs)
+			if len(hunts) != 1 {
+				t.Fatalf("got %d hunts, want 1", len(hunts))
+			}
+			if hunts[0].SQL != tt.sql {
+				t.Errorf("got SQL %s, want %s", hunts[0].SQL, tt.sql)
+			}
+			if dsl, _ := json.Marshal(hunts[0].OpenSearch); string(dsl) != tt.dsl {
+				t.Errorf("got OpenSearch %s, want %s", dsl, tt.dsl)
+			}
+		})
+	}
+}
+
+func TestRetroHuntQueriesRefs(t *testing.T) {
+	bad := &IndicatorNode{ID: "bad", Pattern: &Pattern{Type: "host", Value: "x"}}
+	loop := &IndicatorNode{ID: "loop", Operator: "OR"}
+	loop.Children = []*IndicatorNode{leaf("host", "y"), {Ref: "loop"}}
+
+	defs := &IndicatorDefinitions{Definitions: []*IndicatorNode{
+		bad,
+		rule("uses-ref", &IndicatorNode{Operator: "AND",
+			Children: []*IndicatorNode{{Ref: "bad"}, leaf("port", "80")}}),
+		rule("loop", loop),
+		rule("unknown-ref", &IndicatorNode{Operator: "OR",
+			Children: []*IndicatorNode{{Ref: "missing"}}}),
+		rule("unsupported", &IndicatorNode{Pattern: &Pattern{Type: "url", Value: ".*", Match: "regex"}}),
+	}}
+
+	hunts := RetroHuntQueries(defs)
+	if len(hunts) != 1 {
+		t.Fatalf("got %d hunts, want only the rule using a good reference", len(hunts))
+	}
+	if want := `("host" = 'x' AND "port" = '80')`; hunts[0].SQL != want {
+		t.Errorf("got SQL %s, want %s", hunts[0].SQL, want)
+	}
+}
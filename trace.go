@@ -0,0 +1,158 @@
+package indicators
+
+import (
+	"sync/atomic"
+	"time"
+
+	dt "github.com/trustnetworks/analytics-common/datatypes"
+)
+
+// Trace is a machine-readable record of the evaluation of a single event,
+// suitable for attaching to a support ticket to explain why an indicator
+// did or didn't fire. Leaf steps (those with no Child) are the candidate
+// leaves that matched the event; the remaining steps show how their truth
+// propagated up the tree.
+// Names of nodes need not be unique (anonymous operators are just named by
+// their operator), so each node is also numbered in order of first
+// evaluation, and steps refer to nodes by number.
+type Trace struct {
+	EventID int         `json:"eventid"`
+	Steps   []TraceStep `json:"steps,omitempty"`
+
+	numbers map[*IndicatorNode]int // node numbers given out so far
+}
+
+// TraceStep is a single evaluation of a node, triggered by the truth of one
+// of its children (or, for a leaf, by a pattern match or NOT resolution).
+type TraceStep struct {
+	Node       int      `json:"node"` // number of the node, unique in the trace
+	Name       string   `json:"name"`
+	Operator   string   `json:"operator,omitempty"`
+	Pattern    *Pattern `json:"pattern,omitempty"`
+	Child      int      `json:"child,omitempty"` // number of the child, 0 for a leaf
+	ChildTruth string   `json:"childtruth"`
+	Truth      string   `json:"truth"`
+	Indicator  string   `json:"indicator,omitempty"` // ID of indicator emitted, if any
+
+	// Time taken by the step, including propagation to the node's parents,
+	// to show where slow rules spend their time
+	Duration time.Duration `json:"duration"`
+
+	start time.Time
+}
+
+// NewTrace returns an empty trace for the given event.
+func NewTrace(evID int) *Trace {
+	return &Trace{EventID: evID}
+}
+
+// TraceSampler hands out traces for 1 in Rate events, giving continuous
+// visibility of real-world propagation without paying the cost of tracing
+// every event. Sampling is per event: a sampled trace records every rule
+// the event's leaves reach, with the time spent in each step.
+// A zero Rate disables tracing. It is safe for concurrent use.
+type TraceSampler struct {
+	Rate uint64
+	n    uint64
+}
+
+// Next returns a new trace for the event if it is sampled, otherwise nil.
+// The result can be passed straight to FireWithTrace or ResolveNotWithTrace,
+// which do no tracing when given nil.
+func (s *TraceSampler) Next(evID int) *Trace {
+	if s.Rate == 0 || atomic.AddUint64(&s.n, 1)%s.Rate != 0 {
+		return nil
+	}
+	return NewTrace(evID)
+}
+
+// Truths returns the final truth of every node evaluated during the event,
+// keyed by node number.
+func (tr *Trace) Truths() map[int]string {
+	truths := make(map[int]string)
+	for _, step := range tr.Steps {
+		truths[step.Node] = step.Truth
+	}
+	return truths
+}
+
+func (t truth) String() string {
+	switch t {
+	case truthTrue:
+		return "true"
+	case truthFalse:
+		return "false"
+	default:
+		return "unknown"
+	}
+}
+
+// name returns a human readable name for a node: its ID if it has one,
+// otherwise the ID of its indicator or its pattern.
+func (node *IndicatorNode) name() string {
+	switch {
+	case node.ID != "":
+		return node.ID
+	case node.Indicator != nil:
+		return node.Indicator.Id
+	case node.Pattern != nil && node.Operator == "":
+		return node.Pattern.Type + ":" + node.Pattern.Value
+	default:
+		return node.Operator
+	}
+}
+
+// record adds a step to the trace, returning its index. Safe to call on a
+// nil trace, in which case nothing is recorded.
+func (tr *Trace) record(node, childNode *IndicatorNode, setNodeTo truth) int {
+	if tr == nil {
+		return -1
+	}
+
+	step := TraceStep{
+		Node:       tr.number(node),
+		Name:       node.name(),
+		Operator:   node.Operator,
+		ChildTruth: childNode.truth.String(),
+		Truth:      setNodeTo.String(),
+		start:      time.Now(),
+	}
+	if node.Operator == "" {
+		step.Pattern = node.Pattern
+	}
+	if childNode != &trueNode && childNode != &falseNode {
+		step.Child = tr.number(childNode)
+	}
+
+	tr.Steps = append(tr.Steps, step)
+	return len(tr.Steps) - 1
+}
+
+// number returns the node's number in the trace, numbering it if it is new.
+func (tr *Trace) number(node *IndicatorNode) int {
+	if tr.numbers == nil {
+		tr.numbers = make(map[*IndicatorNode]int)
+	}
+	n, ok := tr.numbers[node]
+	if !ok {
+		n = len(tr.numbers) + 1
+		tr.numbers[node] = n
+	}
+	return n
+}
+
+// finished notes the time taken by a recorded step.
+func (tr *Trace) finished(step int) {
+	if tr == nil || step < 0 {
+		return
+	}
+	tr.Steps[step].Duration = time.Since(tr.Steps[step].start)
+}
+
+// emitted notes in a recorded step that the node's indicator was emitted.
+func (tr *Trace) emitted(step int, ind *dt.Indicator) {
+	if tr == nil || step < 0 {
+		return
+	}
+	tr.Steps[step].Indicator = ind.Id
+}
@@ -0,0 +1,45 @@
+package indicators
+
+import (
+	"testing"
+)
+
+func TestTraceTruthsOfAnonymousNodes(t *testing.T) {
+	x, y, z := leaf("host", "x"), leaf("host", "y"), leaf("host", "z")
+	and1 := &IndicatorNode{Operator: "AND", Children: []*IndicatorNode{x, z}}
+	and2 := &IndicatorNode{Operator: "AND", Children: []*IndicatorNode{y}}
+	link(&IndicatorNode{Operator: "OR", Children: []*IndicatorNode{and1, and2}})
+
+	tr := NewTrace(1)
+	x.FireWithTrace(1, tr)
+	y.FireWithTrace(1, tr)
+
+	want := map[string]string{"x": "true", "and1": "unknown", "y": "true", "and2": "true", "or": "true"}
+	nodes := map[string]int{"x": 1, "and1": 2, "y": 3, "and2": 4, "or": 5}
+	truths := tr.Truths()
+	if len(truths) != len(want) {
+		t.Fatalf("got %d truths, want %d: %v", len(truths), len(want), truths)
+	}
+	for name, truth := range want {
+		if got := truths[nodes[name]]; got != truth {
+			t.Errorf("%s: got truth %s, want %s", name, got, truth)
+		}
+	}
+}
+
+func TestTraceSampler(t *testing.T) {
+	s := &TraceSampler{Rate: 3}
+	var sampled []int
+	for ev := 1; ev <= 7; ev++ {
+		if tr := s.Next(ev); tr != nil {
+			sampled = append(sampled, tr.EventID)
+		}
+	}
+	if len(sampled) != 2 || sampled[0] != 3 || sampled[1] != 6 {
+		t.Errorf("got sampled events %v, want [3 6]", sampled)
+	}
+
+	if (&TraceSampler{}).Next(1) != nil {
+		t.Errorf("zero Rate sampled an event")
+	}
+}
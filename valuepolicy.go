@@ -0,0 +1,117 @@
+package indicators
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ValuePolicy decides what value an Indicator is given when its node fires.
+type ValuePolicy string
+
+const (
+	// ValuePolicyPattern uses the value of the pattern that matched. This is
+	// the default.
+	ValuePolicyPattern ValuePolicy = "pattern"
+
+	// ValuePolicyOriginal keeps the value the Indicator was defined with.
+	ValuePolicyOriginal ValuePolicy = "original"
+
+	// ValuePolicyTemplate uses the node's ValueTemplate, with "{type}" and
+	// "{value}" replaced by the type and value of the pattern that matched.
+	ValuePolicyTemplate ValuePolicy = "template"
+
+	// ValuePolicyAll uses the values of all the patterns that matched below
+	// the node, comma separated.
+	ValuePolicyAll ValuePolicy = "all"
+)
+
+// UnmarshalJSON decodes a value policy, rejecting unrecognised policies so
+// mistakes are caught when the definitions are loaded.
+func (p *ValuePolicy) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch policy := ValuePolicy(s); policy {
+	case "", ValuePolicyPattern, ValuePolicyOriginal, ValuePolicyTemplate, ValuePolicyAll:
+		*p = policy
+		return nil
+	}
+	return fmt.Errorf("unrecognised value policy '%s'", s)
+}
+
+// UnmarshalJSON decodes a node, rejecting a template value policy without a
+// template, which would otherwise emit empty values.
+func (node *IndicatorNode) UnmarshalJSON(b []byte) error {
+	type plain IndicatorNode // without this method, to avoid recursion
+	if err := json.Unmarshal(b, (*plain)(node)); err != nil {
+		return err
+	}
+	if node.ValuePolicy == ValuePolicyTemplate && node.ValueTemplate == "" {
+		return fmt.Errorf("node %s has value policy '%s' but no valuetemplate",
+			node.name(), node.ValuePolicy)
+	}
+	return nil
+}
+
+// valuePolicy returns the node's effective value policy, honouring the
+// deprecated UseOriginalIndicatorValue flag if no policy is given.
+func (node *IndicatorNode) valuePolicy() ValuePolicy {
+	if node.ValuePolicy != "" {
+		return node.ValuePolicy
+	}
+	if node.UseOriginalIndicatorValue {
+		return ValuePolicyOriginal
+	}
+	return ValuePolicyPattern
+}
+
+// indicatorValue returns the value to give the node's Indicator, once its
+// Type has been set from the matched pattern.
+func (node *IndicatorNode) indicatorValue() string {
+	switch node.valuePolicy() {
+
+	case ValuePolicyPattern:
+		return node.Pattern.Value
+
+	case ValuePolicyTemplate:
+		r := strings.NewReplacer(
+			"{type}", node.Indicator.Type,
+			"{value}", node.Pattern.Value)
+		return r.Replace(node.ValueTemplate)
+
+	case ValuePolicyAll:
+		return strings.Join(node.matchedValues(nil), ",")
+
+	default:
+		log.Warnf("Indicator %s has unrecognised value policy '%s'",
+			node.Indicator.Id, node.ValuePolicy)
+		return node.Pattern.Value
+	}
+}
+
+// matchedValues appends to values the distinct values of the leaf patterns
+// under this node that are true for the current event.
+func (node *IndicatorNode) matchedValues(values []string) []string {
+	if node.Operator == "" {
+		if node.Pattern == nil {
+			return values
+		}
+		for _, v := range values {
+			if v == node.Pattern.Value {
+				return values
+			}
+		}
+		return append(values, node.Pattern.Value)
+	}
+
+	for _, child := range node.Children {
+		if child.eventID == node.eventID && child.truth == truthTrue {
+			values = child.matchedValues(values)
+		}
+	}
+	return values
+}
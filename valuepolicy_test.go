@@ -0,0 +1,85 @@
+package indicators
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValuePolicyDecode(t *testing.T) {
+	tests := []struct {
+		json string
+		want ValuePolicy
+		ok   bool
+	}{
+		{`{}`, ValuePolicyPattern, true},
+		{`{"valuepolicy":"template"}`, "", false},
+		{`{"valuepolicy":"all"}`, ValuePolicyAll, true},
+		{`{"UseOriginalIndicatorValue":true}`, ValuePolicyOriginal, true},
+		{`{"valuepolicy":"orginal"}`, "", false},
+		{`{"valuepolicy":"template","valuetemplate":"{value}"}`, ValuePolicyTemplate, true},
+		{`{"children":[{"valuepolicy":"template"}]}`, "", false},
+	}
+
+	for _, tt := range tests {
+		var node IndicatorNode
+		err := json.Unmarshal([]byte(tt.json), &node)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want ok %v", tt.json, err, tt.ok)
+			continue
+		}
+		if tt.ok && node.valuePolicy() != tt.want {
+			t.Errorf("%s: got policy %s, want %s", tt.json, node.valuePolicy(), tt.want)
+		}
+	}
+}
+
+func TestValuePolicies(t *testing.T) {
+	fire := func(policy ValuePolicy, template string) *IndicatorNode {
+		node := rule("r", leaf("src.ipv4", "6.6.6.6"))
+		node.Indicator.Value = "defined"
+		node.ValuePolicy = policy
+		node.ValueTemplate = template
+		node.Fire(1)
+		return node
+	}
+
+	tests := []struct {
+		policy   ValuePolicy
+		template string
+		want     string
+	}{
+		{"", "", "6.6.6.6"},
+		{ValuePolicyPattern, "", "6.6.6.6"},
+		{ValuePolicyOriginal, "", "defined"},
+		{ValuePolicyTemplate, "{type} address {value}", "ipv4 address 6.6.6.6"},
+		{ValuePolicyAll, "", "6.6.6.6"},
+	}
+	for _, tt := range tests {
+		if got := fire(tt.policy, tt.template).Indicator.Value; got != tt.want {
+			t.Errorf("policy %q: got value %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestValuePolicyAll(t *testing.T) {
+	// AND of distinct values, with a duplicate
+	a, b, dup := leaf("host", "a"), leaf("host", "b"), leaf("dns", "a")
+	and := link(rule("and", &IndicatorNode{Operator: "AND",
+		Children: []*IndicatorNode{a, b, dup}, ValuePolicy: ValuePolicyAll}))
+	a.Fire(1)
+	b.Fire(1)
+	dup.Fire(1)
+	if and.Indicator.Value != "a,b" {
+		t.Errorf("AND: got value %q, want \"a,b\"", and.Indicator.Value)
+	}
+
+	// OR where a child was true in an earlier event only
+	x, y := leaf("host", "x"), leaf("host", "y")
+	or := link(rule("or", &IndicatorNode{Operator: "OR",
+		Children: []*IndicatorNode{x, y}, ValuePolicy: ValuePolicyAll}))
+	y.Fire(1)
+	x.Fire(2)
+	if or.Indicator.Value != "x" {
+		t.Errorf("OR: got value %q, want \"x\"", or.Indicator.Value)
+	}
+}